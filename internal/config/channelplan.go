@@ -0,0 +1,61 @@
+package config
+
+import "time"
+
+// Region identifies a LoRaWAN regional parameters revision.
+type Region string
+
+// Named regions. The simulator has no built-in channel plan for any region:
+// channels, data rates and duty cycles always come from the configured
+// ChannelPlan, and its Region value is not otherwise interpreted.
+const (
+	RegionEU868 Region = "EU868"
+	RegionUS915 Region = "US915"
+	RegionRU864 Region = "RU864"
+	RegionAS923 Region = "AS923"
+)
+
+// Channel describes a single uplink channel of a ChannelPlan.
+type Channel struct {
+	// Frequency holds the channel frequency, in Hz.
+	Frequency uint32 `mapstructure:"frequency"`
+
+	// Subband groups channels that share a duty-cycle limit (e.g. the
+	// EU868 g1/g2/g3 subbands).
+	Subband string `mapstructure:"subband"`
+}
+
+// DataRate describes a single entry of a region's data-rate table.
+type DataRate struct {
+	Bandwidth       uint32 `mapstructure:"bandwidth"`
+	SpreadingFactor uint32 `mapstructure:"spreading_factor"`
+}
+
+// DutyCycle describes the duty-cycle limit enforced for a subband.
+type DutyCycle struct {
+	Subband string `mapstructure:"subband"`
+
+	// Limit holds the fraction of time the subband may be used for
+	// transmission, e.g. 0.01 for the EU868 1% subbands.
+	Limit float64 `mapstructure:"limit"`
+}
+
+// ChannelPlan describes the legal uplink channels, data-rate table and
+// duty-cycle / dwell-time constraints of a single LoRaWAN region.
+type ChannelPlan struct {
+	Region Region `mapstructure:"region"`
+
+	// Channels holds the uplink channels a device may rotate through.
+	Channels []Channel `mapstructure:"channels"`
+
+	// DataRates holds the region's data-rate table, indexed by DR.
+	DataRates []DataRate `mapstructure:"data_rates"`
+
+	// DutyCycles holds the duty-cycle limit per subband. Regions without a
+	// duty-cycle restriction (e.g. US915) can leave this empty.
+	DutyCycles []DutyCycle `mapstructure:"duty_cycles"`
+
+	// DwellTime holds the max. dwell time per transmission (e.g. 400ms for
+	// AS923 in dwell-time-limited sub-regions). Zero means unrestricted.
+	DwellTime time.Duration `mapstructure:"dwell_time"`
+}
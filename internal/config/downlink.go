@@ -0,0 +1,25 @@
+package config
+
+import "time"
+
+// Downlink holds the configuration for the simulated downlink traffic that
+// is enqueued against the application-server on a schedule.
+type Downlink struct {
+	// Interval holds the interval at which a downlink is enqueued per device.
+	// When zero, no downlinks are enqueued.
+	Interval time.Duration `mapstructure:"interval"`
+
+	// FPort holds the FPort to enqueue the downlink on.
+	FPort uint8 `mapstructure:"f_port"`
+
+	// Payload holds the hex-encoded downlink payload.
+	Payload string `mapstructure:"payload"`
+
+	// Confirmed enqueues the downlink as a confirmed downlink.
+	Confirmed bool `mapstructure:"confirmed"`
+
+	// AckTimeout holds how long to wait for a confirmed downlink to be
+	// acknowledged before it is counted as lost. When zero, a built-in
+	// default is used.
+	AckTimeout time.Duration `mapstructure:"ack_timeout"`
+}
@@ -0,0 +1,9 @@
+package config
+
+// Metrics holds the configuration for the Prometheus /metrics HTTP
+// endpoint exposed by the simulator.
+type Metrics struct {
+	// Bind holds the ip:port to bind the /metrics HTTP endpoint to.
+	// When left empty, the metrics endpoint is not started.
+	Bind string `mapstructure:"bind"`
+}
@@ -0,0 +1,124 @@
+// Package metrics exposes Prometheus instrumentation for the simulator so
+// that a simulation run can double as a load / perf test against a
+// ChirpStack deployment.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	uplinkRoundTripDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "chirpstack_simulator",
+		Subsystem: "uplink",
+		Name:      "round_trip_duration_seconds",
+		Help:      "Time between the simulator sending an uplink and the application integration event for it arriving.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"dev_eui", "gateway_id"})
+
+	joinAcceptTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "chirpstack_simulator",
+		Subsystem: "join",
+		Name:      "accept_total",
+		Help:      "Number of join-accepts, labeled by result (success, failure).",
+	}, []string{"result"})
+
+	uplinkPerDRTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "chirpstack_simulator",
+		Subsystem: "uplink",
+		Name:      "per_dr_total",
+		Help:      "Number of uplinks received, labeled by data-rate index.",
+	}, []string{"dr"})
+
+	downlinkAckDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "chirpstack_simulator",
+		Subsystem: "downlink",
+		Name:      "ack_duration_seconds",
+		Help:      "Time between enqueueing a confirmed downlink and the device acknowledging it.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	downlinkLossTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "chirpstack_simulator",
+		Subsystem: "downlink",
+		Name:      "loss_total",
+		Help:      "Number of confirmed downlinks that were never acknowledged by the device.",
+	})
+
+	downlinkGatewayMismatchTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "chirpstack_simulator",
+		Subsystem: "downlink",
+		Name:      "gateway_mismatch_total",
+		Help:      "Number of downlinks scheduled by the network-server on a gateway that never reported an uplink for the device, i.e. an unreachable RX1/RX2 gateway.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		uplinkRoundTripDuration,
+		joinAcceptTotal,
+		uplinkPerDRTotal,
+		downlinkAckDuration,
+		downlinkLossTotal,
+		downlinkGatewayMismatchTotal,
+	)
+}
+
+// Serve starts the /metrics HTTP endpoint on the given bind address. It
+// blocks until the listener returns an error and is intended to be run in
+// its own goroutine.
+func Serve(bind string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	if err := http.ListenAndServe(bind, mux); err != nil {
+		return errors.Wrap(err, "metrics: listen and serve error")
+	}
+
+	return nil
+}
+
+// ObserveUplinkRoundTrip records the duration between the simulator sending
+// an uplink and the application integration event for it arriving.
+func ObserveUplinkRoundTrip(devEUI, gatewayID string, d time.Duration) {
+	uplinkRoundTripDuration.WithLabelValues(devEUI, gatewayID).Observe(d.Seconds())
+}
+
+// IncJoinAcceptSuccess increments the join-accept success counter.
+func IncJoinAcceptSuccess() {
+	joinAcceptTotal.WithLabelValues("success").Inc()
+}
+
+// IncJoinAcceptFailure increments the join-accept failure counter.
+func IncJoinAcceptFailure() {
+	joinAcceptTotal.WithLabelValues("failure").Inc()
+}
+
+// IncUplinkPerDR increments the per-data-rate uplink counter.
+func IncUplinkPerDR(dr uint32) {
+	uplinkPerDRTotal.WithLabelValues(strconv.FormatUint(uint64(dr), 10)).Inc()
+}
+
+// ObserveDownlinkAck records the duration between enqueueing a confirmed
+// downlink and the device acknowledging it.
+func ObserveDownlinkAck(d time.Duration) {
+	downlinkAckDuration.Observe(d.Seconds())
+}
+
+// IncDownlinkLoss increments the confirmed-downlink loss counter.
+func IncDownlinkLoss() {
+	downlinkLossTotal.Inc()
+}
+
+// IncDownlinkGatewayMismatch increments the counter for downlinks scheduled
+// by the network-server on a gateway that was not known to be reachable by
+// the target device.
+func IncDownlinkGatewayMismatch() {
+	downlinkGatewayMismatchTotal.Inc()
+}
@@ -0,0 +1,149 @@
+package simulator
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/chirpstack/chirpstack/api/go/v4/gw"
+
+	"github.com/brocaar/chirpstack-simulator/internal/config"
+)
+
+// channelRotator picks the uplink channel and data-rate for a single
+// simulated device. It rotates through the channels of a config.ChannelPlan
+// and honors each subband's duty-cycle limit, and tracks the DR/TxPower an
+// ADR command from the network-server last assigned to the device.
+type channelRotator struct {
+	plan config.ChannelPlan
+
+	mu          sync.Mutex
+	next        int
+	dr          int
+	txPower     int
+	subbandFree map[string]time.Time
+}
+
+func newChannelRotator(plan config.ChannelPlan) *channelRotator {
+	return &channelRotator{
+		plan:        plan,
+		subbandFree: make(map[string]time.Time),
+	}
+}
+
+// txInfo returns the UplinkTxInfo to use for the next transmission of a
+// payload of payloadLen bytes. It rotates through the plan's channels,
+// skipping subbands that are still inside their duty-cycle off-time, and
+// falls back to the legacy static frequency/bandwidth/spreading-factor when
+// no plan is configured.
+func (r *channelRotator) txInfo(fallback gw.UplinkTxInfo, payloadLen int) gw.UplinkTxInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.plan.Channels) == 0 {
+		return fallback
+	}
+
+	now := time.Now()
+	ch := r.plan.Channels[r.next%len(r.plan.Channels)]
+	free := false
+	for i := 0; i < len(r.plan.Channels); i++ {
+		candidate := r.plan.Channels[(r.next+i)%len(r.plan.Channels)]
+		if freeAt, ok := r.subbandFree[candidate.Subband]; !ok || !now.Before(freeAt) {
+			ch = candidate
+			r.next += i + 1
+			free = true
+			break
+		}
+	}
+
+	dr := r.dataRate()
+
+	if !free {
+		// every subband is still inside its duty-cycle off-time; transmit
+		// on the next channel in rotation anyway rather than stalling the
+		// device, but leave its off-timer untouched so the violation isn't
+		// compounded into an ever-growing off-time.
+		r.next++
+	} else if limit := r.dutyCycleLimit(ch.Subband); limit > 0 {
+		onAir := timeOnAir(dr.Bandwidth, dr.SpreadingFactor, payloadLen)
+		offTime := time.Duration(float64(onAir) / limit)
+		r.subbandFree[ch.Subband] = now.Add(offTime)
+	}
+
+	return gw.UplinkTxInfo{
+		Frequency: ch.Frequency,
+		Modulation: &gw.Modulation{
+			Parameters: &gw.Modulation_Lora{
+				Lora: &gw.LoraModulationInfo{
+					Bandwidth:       dr.Bandwidth,
+					SpreadingFactor: dr.SpreadingFactor,
+					CodeRate:        gw.CodeRate_CR_4_5,
+				},
+			},
+		},
+		Power: int32(r.txPower),
+	}
+}
+
+// timeOnAir computes the Semtech LoRa time-on-air for a payloadLen-byte
+// uplink at the given bandwidth/spreading-factor, assuming an explicit
+// header, CRC enabled and an 8 symbol preamble, with low data-rate
+// optimization applied per the LoRaWAN regional parameters rule of thumb
+// (symbol duration >= 16ms).
+func timeOnAir(bandwidth, spreadingFactor uint32, payloadLen int) time.Duration {
+	if bandwidth == 0 || spreadingFactor == 0 {
+		return 0
+	}
+
+	sf := float64(spreadingFactor)
+	symbolDuration := math.Pow(2, sf) / float64(bandwidth)
+
+	var de float64
+	if symbolDuration >= 0.016 {
+		de = 1
+	}
+
+	const (
+		preambleSymbols = 8
+		crc             = 1
+		header          = 0
+		codingRate      = 1 // 4/5
+	)
+
+	payloadSymbNb := 8 + math.Max(
+		math.Ceil((8*float64(payloadLen)-4*sf+28+16*crc-20*header)/(4*(sf-2*de)))*(codingRate+4),
+		0,
+	)
+
+	tPreamble := (preambleSymbols + 4.25) * symbolDuration
+	tPayload := payloadSymbNb * symbolDuration
+
+	return time.Duration((tPreamble + tPayload) * float64(time.Second))
+}
+
+func (r *channelRotator) dutyCycleLimit(subband string) float64 {
+	for _, dc := range r.plan.DutyCycles {
+		if dc.Subband == subband {
+			return dc.Limit
+		}
+	}
+	return 0
+}
+
+func (r *channelRotator) dataRate() config.DataRate {
+	if r.dr < 0 || r.dr >= len(r.plan.DataRates) {
+		return config.DataRate{Bandwidth: 125000, SpreadingFactor: 7}
+	}
+	return r.plan.DataRates[r.dr]
+}
+
+// adr applies a (DR, TxPower) pair received in an ADR MAC command so that
+// subsequent uplinks use it.
+func (r *channelRotator) adr(dr, txPower int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.dr = dr
+	r.txPower = txPower
+}
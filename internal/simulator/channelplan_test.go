@@ -0,0 +1,95 @@
+package simulator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chirpstack/chirpstack/api/go/v4/gw"
+
+	"github.com/brocaar/chirpstack-simulator/internal/config"
+)
+
+func TestTimeOnAir(t *testing.T) {
+	tests := []struct {
+		name            string
+		bandwidth       uint32
+		spreadingFactor uint32
+		payloadLen      int
+		want            time.Duration
+	}{
+		// Expected values computed independently from the same Semtech
+		// formula (explicit header, CRC enabled, 4/5 coding rate, 8 symbol
+		// preamble, low-data-rate-optimization at symbol duration >= 16ms).
+		{"SF7BW125 1 byte", 125000, 7, 1, 25856 * time.Microsecond},
+		{"SF12BW125 1 byte", 125000, 12, 1, 827392 * time.Microsecond},
+		{"SF9BW125 10 bytes", 125000, 9, 10, 144384 * time.Microsecond},
+		{"zero bandwidth", 0, 7, 1, 0},
+		{"zero spreading factor", 125000, 0, 1, 0},
+	}
+
+	const tolerance = 5 * time.Microsecond
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := timeOnAir(tt.bandwidth, tt.spreadingFactor, tt.payloadLen)
+
+			diff := got - tt.want
+			if diff < 0 {
+				diff = -diff
+			}
+			if diff > tolerance {
+				t.Errorf("timeOnAir(%d, %d, %d) = %s, want %s (+/- %s)",
+					tt.bandwidth, tt.spreadingFactor, tt.payloadLen, got, tt.want, tolerance)
+			}
+		})
+	}
+}
+
+func TestChannelRotatorTxInfoSkipsBusySubband(t *testing.T) {
+	plan := config.ChannelPlan{
+		Channels: []config.Channel{
+			{Frequency: 868100000, Subband: "g1"},
+			{Frequency: 868300000, Subband: "g1"},
+			{Frequency: 868500000, Subband: "g2"},
+		},
+		DataRates: []config.DataRate{
+			{Bandwidth: 125000, SpreadingFactor: 7},
+		},
+		DutyCycles: []config.DutyCycle{
+			{Subband: "g1", Limit: 0.01},
+			{Subband: "g2", Limit: 0.01},
+		},
+	}
+
+	r := newChannelRotator(plan)
+
+	first := r.txInfo(gw.UplinkTxInfo{}, 10)
+	if first.Frequency != plan.Channels[0].Frequency {
+		t.Fatalf("first txInfo frequency = %d, want %d", first.Frequency, plan.Channels[0].Frequency)
+	}
+
+	// g1 is now inside its duty-cycle off-time, so the next call must skip
+	// the second g1 channel and pick g2 even though rotation would otherwise
+	// land on that g1 channel.
+	second := r.txInfo(gw.UplinkTxInfo{}, 10)
+	if second.Frequency != plan.Channels[2].Frequency {
+		t.Fatalf("second txInfo frequency = %d, want %d (g1 should still be busy)", second.Frequency, plan.Channels[2].Frequency)
+	}
+
+	// both subbands are now busy: txInfo must still return a channel rather
+	// than blocking or panicking.
+	third := r.txInfo(gw.UplinkTxInfo{}, 10)
+	if third.Frequency != plan.Channels[0].Frequency && third.Frequency != plan.Channels[1].Frequency && third.Frequency != plan.Channels[2].Frequency {
+		t.Fatalf("third txInfo frequency = %d, want one of the plan's channels", third.Frequency)
+	}
+}
+
+func TestChannelRotatorTxInfoFallback(t *testing.T) {
+	r := newChannelRotator(config.ChannelPlan{})
+
+	fallback := gw.UplinkTxInfo{Frequency: 868500000}
+	got := r.txInfo(fallback, 10)
+	if got.Frequency != fallback.Frequency {
+		t.Fatalf("txInfo with empty plan = %+v, want fallback %+v", got, fallback)
+	}
+}
@@ -5,10 +5,12 @@ import (
 	"crypto/rand"
 	"encoding/csv"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	mrand "math/rand"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -20,6 +22,7 @@ import (
 
 	"github.com/brocaar/chirpstack-simulator/internal/as"
 	"github.com/brocaar/chirpstack-simulator/internal/config"
+	"github.com/brocaar/chirpstack-simulator/internal/metrics"
 	"github.com/brocaar/chirpstack-simulator/internal/ns"
 	"github.com/brocaar/chirpstack-simulator/simulator"
 	"github.com/brocaar/lorawan"
@@ -27,8 +30,39 @@ import (
 	"github.com/chirpstack/chirpstack/api/go/v4/gw"
 )
 
+// joinAcceptTimeout is the grace period given to an OTAA device, on top of
+// its join delay, before its join is considered to have failed. The
+// simulator emulates OTAA by activating the device's locally-derived session
+// server-side (see activateOTAADevice) rather than running a real
+// join-request/join-accept exchange; if that activation has not cleared the
+// device's pendingJoins entry by the time this elapses, the join is counted
+// as a failure.
+const joinAcceptTimeout = 10 * time.Second
+
+// defaultDownlinkAckTimeout is used when config.Downlink.AckTimeout is left
+// at its zero value: how long to wait for a confirmed downlink's event/ack
+// before counting it as lost.
+const defaultDownlinkAckTimeout = 30 * time.Second
+
+// uplinkKey identifies a single uplink transmission, so that the send-time
+// recorded by WithUplinkSentHandler for uplink N is never confused with a
+// later uplink N+1 from the same device whose event/up happens to arrive
+// first.
+type uplinkKey struct {
+	devEUI lorawan.EUI64
+	fCnt   uint32
+}
+
 // Start starts the simulator.
 func Start(ctx context.Context, wg *sync.WaitGroup, c config.Config) error {
+	if c.Metrics.Bind != "" {
+		go func() {
+			if err := metrics.Serve(c.Metrics.Bind); err != nil {
+				log.WithError(err).Error("simulator: metrics server error")
+			}
+		}()
+	}
+
 	for i, c := range c.Simulator {
 		log.WithFields(log.Fields{
 			"i": i,
@@ -56,9 +90,21 @@ func Start(ctx context.Context, wg *sync.WaitGroup, c config.Config) error {
 			duration:             c.Duration,
 			gatewayMinCount:      c.Gateway.MinCount,
 			gatewayMaxCount:      c.Gateway.MaxCount,
+			channelPlan:          c.ChannelPlan,
 			deviceAppKeys:        make(map[lorawan.EUI64]lorawan.AES128Key),
+			deviceInfo:           make(map[lorawan.EUI64]Device),
 			eventTopicTemplate:   c.Gateway.EventTopicTemplate,
 			commandTopicTemplate: c.Gateway.CommandTopicTemplate,
+			downlinkInterval:     c.Downlink.Interval,
+			downlinkFPort:        c.Downlink.FPort,
+			downlinkPayload:      c.Downlink.Payload,
+			downlinkConfirmed:    c.Downlink.Confirmed,
+			downlinkAckTimeout:   c.Downlink.AckTimeout,
+			downlinkSentAt:       make(map[lorawan.EUI64]time.Time),
+			uplinkSentAt:         make(map[uplinkKey]time.Time),
+			pendingJoins:         make(map[lorawan.EUI64]bool),
+			deviceGateways:       make(map[lorawan.EUI64]map[string]bool),
+			devAddrToDevEUI:      make(map[lorawan.DevAddr]lorawan.EUI64),
 		}
 
 		go sim.start()
@@ -83,6 +129,7 @@ type simulation struct {
 	frequency       int
 	bandwidth       int
 	spreadingFactor int
+	channelPlan     config.ChannelPlan
 
 	tenant               *api.Tenant
 	deviceProfileID      uuid.UUID
@@ -90,8 +137,33 @@ type simulation struct {
 	gatewayIDs           []lorawan.EUI64
 	deviceAppKeysMutex   sync.Mutex
 	deviceAppKeys        map[lorawan.EUI64]lorawan.AES128Key
+	deviceInfoMutex      sync.Mutex
+	deviceInfo           map[lorawan.EUI64]Device
 	eventTopicTemplate   string
 	commandTopicTemplate string
+
+	downlinkInterval   time.Duration
+	downlinkFPort      uint8
+	downlinkPayload    string
+	downlinkConfirmed  bool
+	downlinkAckTimeout time.Duration
+	downlinkStop       chan struct{}
+	downlinkSentAtMu   sync.Mutex
+	downlinkSentAt     map[lorawan.EUI64]time.Time
+
+	uplinkSentAtMu sync.Mutex
+	uplinkSentAt   map[uplinkKey]time.Time
+
+	pendingJoinsMu sync.Mutex
+	pendingJoins   map[lorawan.EUI64]bool
+
+	// deviceGatewaysMu guards deviceGateways and devAddrToDevEUI, both
+	// populated once per device (in runSimulation, and for the DevAddr of an
+	// OTAA device in activateOTAADevice) and read by the command/down
+	// correlation handler handleDownlinkFrame.
+	deviceGatewaysMu sync.Mutex
+	deviceGateways   map[lorawan.EUI64]map[string]bool
+	devAddrToDevEUI  map[lorawan.DevAddr]lorawan.EUI64
 }
 
 func (s *simulation) start() {
@@ -141,12 +213,20 @@ func (s *simulation) init() error {
 		return err
 	}
 
+	if err := s.setupDownlinkIntegration(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
 func (s *simulation) tearDown() error {
 	log.Info("simulation: cleaning up")
 
+	if err := s.tearDownDownlinkIntegration(); err != nil {
+		return err
+	}
+
 	if err := s.tearDownApplicationIntegration(); err != nil {
 		return err
 	}
@@ -185,6 +265,10 @@ func (s *simulation) runSimulation() error {
 			return errors.Wrap(err, "new gateway error")
 		}
 		gateways = append(gateways, gw)
+
+		if err := gw.SubscribeCommand(s.handleDownlinkFrame); err != nil {
+			return errors.Wrap(err, "subscribe gateway command topic error")
+		}
 	}
 
 	var wg sync.WaitGroup
@@ -194,7 +278,9 @@ func (s *simulation) runSimulation() error {
 	}
 	defer cancel()
 
-	for devEUI, appKey := range s.deviceAppKeys {
+	for devEUI, dev := range s.deviceInfo {
+		devEUI := devEUI
+
 		devGateways := make(map[int]*simulator.Gateway)
 		devNumGateways := s.gatewayMinCount + mrand.Intn(s.gatewayMaxCount-s.gatewayMinCount+1)
 
@@ -209,26 +295,121 @@ func (s *simulation) runSimulation() error {
 			gws = append(gws, devGateways[k])
 		}
 
-		d, err := simulator.NewDevice(ctx, &wg,
-			simulator.WithDevEUI(devEUI),
-			simulator.WithAppKey(appKey),
-			simulator.WithUplinkInterval(s.uplinkInterval),
-			simulator.WithOTAADelay(time.Duration(mrand.Int63n(int64(s.activationTime)))),
-			simulator.WithUplinkPayload(false, s.fPort, s.payload),
-			simulator.WithGateways(gws),
-			simulator.WithUplinkTXInfo(gw.UplinkTxInfo{
-				Frequency: uint32(s.frequency),
-				Modulation: &gw.Modulation{
-					Parameters: &gw.Modulation_Lora{
-						Lora: &gw.LoraModulationInfo{
-							Bandwidth:       uint32(s.bandwidth),
-							SpreadingFactor: uint32(s.spreadingFactor),
-							CodeRate:        gw.CodeRate_CR_4_5,
-						},
+		gatewaySet := make(map[string]bool, len(gws))
+		for _, gateway := range gws {
+			gatewaySet[gateway.GatewayID().String()] = true
+		}
+		s.deviceGatewaysMu.Lock()
+		s.deviceGateways[devEUI] = gatewaySet
+		s.deviceGatewaysMu.Unlock()
+
+		uplinkInterval := s.uplinkInterval
+		if dev.UplinkInterval != 0 {
+			uplinkInterval = dev.UplinkInterval
+		}
+
+		payload := s.payload
+		if dev.Payload != "" {
+			pl, err := hex.DecodeString(dev.Payload)
+			if err != nil {
+				return errors.Wrap(err, "decode device payload error")
+			}
+			payload = pl
+		}
+
+		staticTXInfo := gw.UplinkTxInfo{
+			Frequency: uint32(s.frequency),
+			Modulation: &gw.Modulation{
+				Parameters: &gw.Modulation_Lora{
+					Lora: &gw.LoraModulationInfo{
+						Bandwidth:       uint32(s.bandwidth),
+						SpreadingFactor: uint32(s.spreadingFactor),
+						CodeRate:        gw.CodeRate_CR_4_5,
 					},
 				},
+			},
+		}
+		rotator := newChannelRotator(s.channelPlan)
+
+		opts := []simulator.DeviceOption{
+			simulator.WithDevEUI(devEUI),
+			simulator.WithUplinkInterval(uplinkInterval),
+			simulator.WithUplinkPayload(false, s.fPort, payload),
+			simulator.WithGateways(gws),
+			simulator.WithUplinkTXInfoFunc(func() gw.UplinkTxInfo {
+				return rotator.txInfo(staticTXInfo, len(payload))
 			}),
-		)
+			simulator.WithADRHandler(rotator.adr),
+			simulator.WithUplinkSentHandler(func(fCnt uint32, sentAt time.Time) {
+				s.uplinkSentAtMu.Lock()
+				s.uplinkSentAt[uplinkKey{devEUI: devEUI, fCnt: fCnt}] = sentAt
+				s.uplinkSentAtMu.Unlock()
+			}),
+		}
+
+		switch dev.Activation {
+		case ActivationABP:
+			var devAddr lorawan.DevAddr
+			var appSKey, nwkSKey lorawan.AES128Key
+			if err := devAddr.UnmarshalText([]byte(dev.DevAddr)); err != nil {
+				return errors.Wrap(err, "unmarshal dev-addr error")
+			}
+			if err := appSKey.UnmarshalText([]byte(dev.AppSKey)); err != nil {
+				return errors.Wrap(err, "unmarshal app-s-key error")
+			}
+			if err := nwkSKey.UnmarshalText([]byte(dev.NwkSKey)); err != nil {
+				return errors.Wrap(err, "unmarshal nwk-s-key error")
+			}
+			opts = append(opts,
+				simulator.WithDevAddr(devAddr),
+				simulator.WithSessionKeys(appSKey, nwkSKey),
+			)
+
+			s.deviceGatewaysMu.Lock()
+			s.devAddrToDevEUI[devAddr] = devEUI
+			s.deviceGatewaysMu.Unlock()
+		default:
+			appKey, ok := s.deviceAppKeys[devEUI]
+			if !ok {
+				return errors.Errorf("no app-key registered for OTAA device %s", devEUI)
+			}
+			otaaDelay := time.Duration(mrand.Int63n(int64(s.activationTime)))
+			opts = append(opts,
+				simulator.WithAppKey(appKey),
+				simulator.WithOTAADelay(otaaDelay),
+				simulator.WithSessionEstablishedHandler(func(devAddr lorawan.DevAddr, appSKey, nwkSKey lorawan.AES128Key) {
+					s.activateOTAADevice(devEUI, devAddr, appSKey, nwkSKey)
+				}),
+			)
+
+			s.pendingJoinsMu.Lock()
+			s.pendingJoins[devEUI] = true
+			s.pendingJoinsMu.Unlock()
+
+			time.AfterFunc(otaaDelay+joinAcceptTimeout, func() {
+				s.pendingJoinsMu.Lock()
+				pending := s.pendingJoins[devEUI]
+				delete(s.pendingJoins, devEUI)
+				s.pendingJoinsMu.Unlock()
+
+				if pending {
+					metrics.IncJoinAcceptFailure()
+				}
+			})
+		}
+
+		switch dev.Class {
+		case DeviceClassB:
+			opts = append(opts, simulator.WithClassB(s.commandTopicTemplate))
+		case DeviceClassC:
+			opts = append(opts, simulator.WithClassC(s.commandTopicTemplate))
+		}
+
+		if dev.ConfirmedUplink {
+			opts = append(opts, simulator.WithConfirmedUplink(true))
+		}
+
+		d, err := simulator.NewDevice(ctx, &wg, opts...)
 		if err != nil {
 			return errors.Wrap(err, "new device error")
 		}
@@ -402,6 +583,22 @@ func (s *simulation) tearDownApplication() error {
 	return nil
 }
 
+// ActivationMode determines whether a device joins over-the-air or is
+// provisioned with a pre-shared session (ABP).
+type ActivationMode string
+
+// DeviceClass is the LoRaWAN device class the simulated device operates as.
+type DeviceClass string
+
+const (
+	ActivationOTAA ActivationMode = "otaa"
+	ActivationABP  ActivationMode = "abp"
+
+	DeviceClassA DeviceClass = "A"
+	DeviceClassB DeviceClass = "B"
+	DeviceClassC DeviceClass = "C"
+)
+
 type Device struct {
 	Name            string
 	DeviceProfileId string
@@ -409,6 +606,15 @@ type Device struct {
 	NwkKey          string
 	JoinEui         string
 	Description     string
+
+	Activation      ActivationMode
+	Class           DeviceClass
+	DevAddr         string
+	AppSKey         string
+	NwkSKey         string
+	UplinkInterval  time.Duration
+	Payload         string
+	ConfirmedUplink bool
 }
 
 // readDevicesFromCSV читает CSV файл и возвращает массив структур Device
@@ -424,6 +630,7 @@ func readDevicesFromCSV(filePath string) ([]Device, error) {
 	reader := csv.NewReader(file)
 	reader.Comma = ';'             // Устанавливаем разделитель точка с запятой
 	reader.TrimLeadingSpace = true // Удаляем пробелы в начале полей
+	reader.FieldsPerRecord = -1    // строки могут иметь разное число полей (опциональные колонки 6-13)
 
 	// Читаем все записи
 	records, err := reader.ReadAll()
@@ -435,6 +642,7 @@ func readDevicesFromCSV(filePath string) ([]Device, error) {
 
 	// Предполагаем, что первая строка - это заголовки
 	// Итерируемся по записям, начиная со второй строки (индекс 1)
+	// Колонки 0-5 как и раньше, далее: activation;class;dev_addr;app_skey;nwk_skey;uplink_interval;payload;confirmed
 	for i, record := range records {
 		// Пропускаем заголовок
 		if i == 0 {
@@ -442,7 +650,7 @@ func readDevicesFromCSV(filePath string) ([]Device, error) {
 		}
 
 		// Проверяем, что в строке достаточно полей
-		if len(record) < 4 {
+		if len(record) < 6 {
 			return nil, fmt.Errorf("недостаточно полей в строке %d", i+1)
 		}
 
@@ -453,6 +661,37 @@ func readDevicesFromCSV(filePath string) ([]Device, error) {
 			NwkKey:          record[3],
 			JoinEui:         record[4],
 			Description:     record[5],
+			Activation:      ActivationOTAA,
+			Class:           DeviceClassA,
+		}
+
+		if len(record) > 6 && record[6] != "" {
+			device.Activation = ActivationMode(strings.ToLower(record[6]))
+		}
+		if len(record) > 7 && record[7] != "" {
+			device.Class = DeviceClass(strings.ToUpper(record[7]))
+		}
+		if len(record) > 8 {
+			device.DevAddr = record[8]
+		}
+		if len(record) > 9 {
+			device.AppSKey = record[9]
+		}
+		if len(record) > 10 {
+			device.NwkSKey = record[10]
+		}
+		if len(record) > 11 && record[11] != "" {
+			interval, err := time.ParseDuration(record[11])
+			if err != nil {
+				return nil, fmt.Errorf("invalid uplink interval at line %d: %s", i+1, err)
+			}
+			device.UplinkInterval = interval
+		}
+		if len(record) > 12 {
+			device.Payload = record[12]
+		}
+		if len(record) > 13 {
+			device.ConfirmedUplink = strings.EqualFold(record[13], "true")
 		}
 
 		devices = append(devices, device)
@@ -475,14 +714,9 @@ func (s *simulation) setupDevices() error {
 		wg.Add(1)
 		go func(dev Device) {
 			var devEUI lorawan.EUI64
-			var appKey lorawan.AES128Key
 
 			fmt.Printf("%+v\n", dev)
 			devEUI.UnmarshalText([]byte(dev.DevEui))
-			//			appKey.UnmarshalText([]byte(dev.NwkKey))
-			if _, err := rand.Read(appKey[:]); err != nil {
-				log.Fatal(err)
-			}
 
 			_, err := as.Device().Create(context.Background(), &api.CreateDeviceRequest{
 				Device: &api.Device{
@@ -497,6 +731,43 @@ func (s *simulation) setupDevices() error {
 				log.Fatal("create device error, error: %s", err)
 			}
 
+			s.deviceInfoMutex.Lock()
+			s.deviceInfo[devEUI] = dev
+			s.deviceInfoMutex.Unlock()
+
+			// ABP devices skip the join procedure entirely: they already have a
+			// DevAddr and session keys, so there is no AppKey to register.
+			// They still need to be activated server-side, otherwise the NS
+			// has no DevAddr to session mapping and drops every uplink.
+			if dev.Activation == ActivationABP {
+				_, err = as.Device().Activate(context.Background(), &api.ActivateDeviceRequest{
+					DeviceActivation: &api.DeviceActivation{
+						DevEui:      devEUI.String(),
+						DevAddr:     dev.DevAddr,
+						AppSKey:     dev.AppSKey,
+
+						// yes, this is correct for LoRaWAN 1.0.x!
+						// see the API documentation
+						NwkSEncKey:  dev.NwkSKey,
+						SNwkSIntKey: dev.NwkSKey,
+						FNwkSIntKey: dev.NwkSKey,
+					},
+				})
+				if err != nil {
+					log.Fatal("activate ABP device error, error: %s", err)
+				}
+
+				log.Info("simulator: init ABP device %s", devEUI.String())
+				wg.Done()
+				return
+			}
+
+			var appKey lorawan.AES128Key
+			//			appKey.UnmarshalText([]byte(dev.NwkKey))
+			if _, err := rand.Read(appKey[:]); err != nil {
+				log.Fatal(err)
+			}
+
 			_, err = as.Device().CreateKeys(context.Background(), &api.CreateDeviceKeysRequest{
 				DeviceKeys: &api.DeviceKeys{
 					DevEui: devEUI.String(),
@@ -572,6 +843,45 @@ func (s *simulation) setupDevices() error {
 	return nil
 }
 
+// activateOTAADevice is called once an OTAA device has derived its session
+// locally (see the doc comment on simulator.WithSessionEstablishedHandler).
+// Since the simulator never sends a real join-request, the network-server
+// never runs its own join-accept either; this activates the same
+// DevAddr/session keys server-side, exactly as setupDevices does for ABP
+// devices, so the NS has a session to validate the device's uplinks
+// against. It also records the DevAddr so the gateway command/down
+// correlation in handleDownlinkFrame can resolve it back to a devEUI, and
+// treats activation success as an emulated join-accept for metrics.
+func (s *simulation) activateOTAADevice(devEUI lorawan.EUI64, devAddr lorawan.DevAddr, appSKey, nwkSKey lorawan.AES128Key) {
+	_, err := as.Device().Activate(context.Background(), &api.ActivateDeviceRequest{
+		DeviceActivation: &api.DeviceActivation{
+			DevEui:  devEUI.String(),
+			DevAddr: devAddr.String(),
+			AppSKey: appSKey.String(),
+
+			// yes, this is correct for LoRaWAN 1.0.x!
+			// see the API documentation
+			NwkSEncKey:  nwkSKey.String(),
+			SNwkSIntKey: nwkSKey.String(),
+			FNwkSIntKey: nwkSKey.String(),
+		},
+	})
+	if err != nil {
+		log.WithError(err).WithField("dev_eui", devEUI.String()).Error("simulator: activate OTAA device error")
+		return
+	}
+
+	s.deviceGatewaysMu.Lock()
+	s.devAddrToDevEUI[devAddr] = devEUI
+	s.deviceGatewaysMu.Unlock()
+
+	s.pendingJoinsMu.Lock()
+	delete(s.pendingJoins, devEUI)
+	s.pendingJoinsMu.Unlock()
+
+	metrics.IncJoinAcceptSuccess()
+}
+
 func (s *simulation) tearDownDevices() error {
 	log.Info("simulator: tear-down devices")
 
@@ -587,15 +897,99 @@ func (s *simulation) tearDownDevices() error {
 	return nil
 }
 
+// uplinkEvent is the subset of the ChirpStack `event/up` integration
+// payload the simulator cares about for metrics purposes.
+type uplinkEvent struct {
+	DeviceInfo struct {
+		DevEui string `json:"devEui"`
+	} `json:"deviceInfo"`
+	Dr     uint32 `json:"dr"`
+	FCnt   uint32 `json:"fCnt"`
+	RxInfo []struct {
+		GatewayId string    `json:"gatewayId"`
+		Time      time.Time `json:"time"`
+	} `json:"rxInfo"`
+}
+
+// joinEvent is the subset of the ChirpStack `event/join` integration
+// payload the simulator cares about for metrics purposes.
+type joinEvent struct {
+	DeviceInfo struct {
+		DevEui string `json:"devEui"`
+	} `json:"deviceInfo"`
+}
+
 func (s *simulation) setupApplicationIntegration() error {
 	log.Info("simulator: setting up application integration")
 
-	token := as.MQTTClient().Subscribe(fmt.Sprintf("application/%s/device/+/event/up", s.applicationID), 0, func(client mqtt.Client, msg mqtt.Message) {
-		applicationUplinkCounter().Inc()
+	upToken := as.MQTTClient().Subscribe(fmt.Sprintf("application/%s/device/+/event/up", s.applicationID), 0, func(client mqtt.Client, msg mqtt.Message) {
+		var up uplinkEvent
+		if err := json.Unmarshal(msg.Payload(), &up); err != nil {
+			log.WithError(err).Error("simulator: unmarshal uplink event error")
+			return
+		}
+
+		metrics.IncUplinkPerDR(up.Dr)
+
+		var devEUI lorawan.EUI64
+		if err := devEUI.UnmarshalText([]byte(up.DeviceInfo.DevEui)); err != nil {
+			log.WithError(err).Error("simulator: unmarshal dev-eui error")
+			return
+		}
+
+		// the round-trip is measured against the time the simulator itself
+		// stamped when this specific uplink (by fCnt, not just devEUI) was
+		// sent, not the gateway-supplied rxInfo.time: that field is
+		// frequently left unset by the gateway bridge, which would
+		// otherwise dump a multi-decade outlier into the +Inf bucket.
+		key := uplinkKey{devEUI: devEUI, fCnt: up.FCnt}
+		s.uplinkSentAtMu.Lock()
+		sentAt, ok := s.uplinkSentAt[key]
+		delete(s.uplinkSentAt, key)
+		s.uplinkSentAtMu.Unlock()
+		if !ok {
+			return
+		}
+
+		var gatewayID string
+		if len(up.RxInfo) > 0 {
+			gatewayID = up.RxInfo[0].GatewayId
+		}
+		metrics.ObserveUplinkRoundTrip(up.DeviceInfo.DevEui, gatewayID, time.Since(sentAt))
 	})
-	token.Wait()
-	if token.Error() != nil {
-		return errors.Wrap(token.Error(), "subscribe application integration error")
+	upToken.Wait()
+	if upToken.Error() != nil {
+		return errors.Wrap(upToken.Error(), "subscribe application integration error")
+	}
+
+	// the simulator emulates OTAA via activateOTAADevice rather than a real
+	// join, so this event is not expected to fire for simulator-driven
+	// devices; it is kept so that a device which does perform a real join
+	// (e.g. against a deployment where the simulator's devEUI is reused by
+	// an external join) still clears pendingJoins and counts as a success
+	// instead of timing out as a failure.
+	joinToken := as.MQTTClient().Subscribe(fmt.Sprintf("application/%s/device/+/event/join", s.applicationID), 0, func(client mqtt.Client, msg mqtt.Message) {
+		var join joinEvent
+		if err := json.Unmarshal(msg.Payload(), &join); err != nil {
+			log.WithError(err).Error("simulator: unmarshal join event error")
+			return
+		}
+
+		var devEUI lorawan.EUI64
+		if err := devEUI.UnmarshalText([]byte(join.DeviceInfo.DevEui)); err != nil {
+			log.WithError(err).Error("simulator: unmarshal dev-eui error")
+			return
+		}
+
+		s.pendingJoinsMu.Lock()
+		delete(s.pendingJoins, devEUI)
+		s.pendingJoinsMu.Unlock()
+
+		metrics.IncJoinAcceptSuccess()
+	})
+	joinToken.Wait()
+	if joinToken.Error() != nil {
+		return errors.Wrap(joinToken.Error(), "subscribe application integration error")
 	}
 
 	return nil
@@ -604,7 +998,10 @@ func (s *simulation) setupApplicationIntegration() error {
 func (s *simulation) tearDownApplicationIntegration() error {
 	log.Info("simulator: tear-down application integration")
 
-	token := as.MQTTClient().Unsubscribe(fmt.Sprintf("application/%s/device/+/event/up", s.applicationID))
+	token := as.MQTTClient().Unsubscribe(
+		fmt.Sprintf("application/%s/device/+/event/up", s.applicationID),
+		fmt.Sprintf("application/%s/device/+/event/join", s.applicationID),
+	)
 	token.Wait()
 	if token.Error() != nil {
 		return errors.Wrap(token.Error(), "unsubscribe application integration error")
@@ -612,3 +1009,199 @@ func (s *simulation) tearDownApplicationIntegration() error {
 
 	return nil
 }
+
+// handleDownlinkFrame is subscribed to every gateway's command/down topic.
+// It resolves the DevAddr carried in the scheduled PHYPayload back to a
+// devEUI and confirms the network-server picked a gateway actually known to
+// be reachable by that device (i.e. one that had forwarded one of its
+// uplinks), rather than relying solely on the application-server's
+// event/ack to infer that RX1/RX2 scheduling worked. devAddrToDevEUI is
+// keyed by the actual activated DevAddr for both ABP and (emulated) OTAA
+// devices, so this resolves correctly for both: for OTAA it is populated by
+// activateOTAADevice with the same DevAddr the device was activated with,
+// not a value fabricated independently of what the NS was told.
+func (s *simulation) handleDownlinkFrame(frame *gw.DownlinkFrame) {
+	if len(frame.GetItems()) == 0 {
+		return
+	}
+
+	var phy lorawan.PHYPayload
+	if err := phy.UnmarshalBinary(frame.GetItems()[0].GetPhyPayload()); err != nil {
+		return
+	}
+
+	mac, ok := phy.MACPayload.(*lorawan.MACPayload)
+	if !ok {
+		return
+	}
+
+	s.deviceGatewaysMu.Lock()
+	devEUI, ok := s.devAddrToDevEUI[mac.FHDR.DevAddr]
+	allowed := ok && s.deviceGateways[devEUI][frame.GetGatewayId()]
+	s.deviceGatewaysMu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	if !allowed {
+		log.WithFields(log.Fields{
+			"dev_eui":    devEUI.String(),
+			"gateway_id": frame.GetGatewayId(),
+		}).Warn("simulator: downlink scheduled through a gateway not known to reach this device")
+		metrics.IncDownlinkGatewayMismatch()
+	}
+}
+
+// ackEvent is the subset of the ChirpStack `event/ack` integration payload
+// the simulator cares about for confirmed-downlink tracking.
+type ackEvent struct {
+	DeviceInfo struct {
+		DevEui string `json:"devEui"`
+	} `json:"deviceInfo"`
+	Acknowledged bool `json:"acknowledged"`
+}
+
+// setupDownlinkIntegration enqueues a downlink per device on the configured
+// interval/cadence and subscribes to the application's ack event so that
+// confirmed downlinks can be correlated against the device's next uplink.
+func (s *simulation) setupDownlinkIntegration() error {
+	log.Info("simulator: setting up downlink integration")
+
+	token := as.MQTTClient().Subscribe(fmt.Sprintf("application/%s/device/+/event/ack", s.applicationID), 0, func(client mqtt.Client, msg mqtt.Message) {
+		var ack ackEvent
+		if err := json.Unmarshal(msg.Payload(), &ack); err != nil {
+			log.WithError(err).Error("simulator: unmarshal ack event error")
+			return
+		}
+
+		var devEUI lorawan.EUI64
+		if err := devEUI.UnmarshalText([]byte(ack.DeviceInfo.DevEui)); err != nil {
+			log.WithError(err).Error("simulator: unmarshal dev-eui error")
+			return
+		}
+
+		s.downlinkSentAtMu.Lock()
+		sentAt, ok := s.downlinkSentAt[devEUI]
+		delete(s.downlinkSentAt, devEUI)
+		s.downlinkSentAtMu.Unlock()
+
+		if !ok {
+			return
+		}
+
+		if ack.Acknowledged {
+			metrics.ObserveDownlinkAck(time.Since(sentAt))
+		} else {
+			metrics.IncDownlinkLoss()
+		}
+	})
+	token.Wait()
+	if token.Error() != nil {
+		return errors.Wrap(token.Error(), "subscribe downlink integration error")
+	}
+
+	if s.downlinkInterval == 0 {
+		return nil
+	}
+
+	pl, err := hex.DecodeString(s.downlinkPayload)
+	if err != nil {
+		return errors.Wrap(err, "decode downlink payload error")
+	}
+
+	s.downlinkStop = make(chan struct{})
+	go s.enqueueDownlinks(pl)
+	go s.sweepUnackedDownlinks()
+
+	return nil
+}
+
+// sweepUnackedDownlinks periodically scans downlinkSentAt for confirmed
+// downlinks that have been outstanding longer than the ack timeout, i.e.
+// true losses for which no event/ack (acknowledged or not) ever arrived at
+// all, and counts them as lost.
+func (s *simulation) sweepUnackedDownlinks() {
+	timeout := s.downlinkAckTimeout
+	if timeout == 0 {
+		timeout = defaultDownlinkAckTimeout
+	}
+
+	ticker := time.NewTicker(timeout)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.downlinkStop:
+			return
+		case <-ticker.C:
+			now := time.Now()
+
+			s.downlinkSentAtMu.Lock()
+			for devEUI, sentAt := range s.downlinkSentAt {
+				if now.Sub(sentAt) < timeout {
+					continue
+				}
+				delete(s.downlinkSentAt, devEUI)
+				metrics.IncDownlinkLoss()
+			}
+			s.downlinkSentAtMu.Unlock()
+		}
+	}
+}
+
+func (s *simulation) enqueueDownlinks(payload []byte) {
+	ticker := time.NewTicker(s.downlinkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.downlinkStop:
+			return
+		case <-ticker.C:
+			s.deviceInfoMutex.Lock()
+			devEUIs := make([]lorawan.EUI64, 0, len(s.deviceInfo))
+			for devEUI := range s.deviceInfo {
+				devEUIs = append(devEUIs, devEUI)
+			}
+			s.deviceInfoMutex.Unlock()
+
+			for _, devEUI := range devEUIs {
+				_, err := as.Device().Enqueue(context.Background(), &api.EnqueueDeviceQueueItemRequest{
+					QueueItem: &api.DeviceQueueItem{
+						DevEui:    devEUI.String(),
+						Confirmed: s.downlinkConfirmed,
+						FPort:     uint32(s.downlinkFPort),
+						Data:      payload,
+					},
+				})
+				if err != nil {
+					log.WithError(err).Error("simulator: enqueue downlink error")
+					continue
+				}
+
+				if s.downlinkConfirmed {
+					s.downlinkSentAtMu.Lock()
+					s.downlinkSentAt[devEUI] = time.Now()
+					s.downlinkSentAtMu.Unlock()
+				}
+			}
+		}
+	}
+}
+
+func (s *simulation) tearDownDownlinkIntegration() error {
+	log.Info("simulator: tear-down downlink integration")
+
+	if s.downlinkStop != nil {
+		close(s.downlinkStop)
+	}
+
+	token := as.MQTTClient().Unsubscribe(fmt.Sprintf("application/%s/device/+/event/ack", s.applicationID))
+	token.Wait()
+	if token.Error() != nil {
+		return errors.Wrap(token.Error(), "unsubscribe downlink integration error")
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package simulator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeCSV(t *testing.T, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "devices.csv")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("write csv: %s", err)
+	}
+	return path
+}
+
+func TestReadDevicesFromCSVRaggedRows(t *testing.T) {
+	// Rows may omit the optional columns (6-13) entirely; the header
+	// defines the widest row, and FieldsPerRecord must not reject shorter
+	// ones.
+	csv := "name;profile;dev_eui;nwk_key;join_eui;desc;activation;class;dev_addr;app_skey;nwk_skey;uplink_interval;payload;confirmed\n" +
+		"dev1;profile1;0102030405060708;00112233445566778899aabbccddeeff;0000000000000000;desc1\n" +
+		"dev2;profile2;0807060504030201;ffeeddccbbaa99887766554433221100;0000000000000000;desc2;abp;A;01020304;appskey;nwkskey;10s;hello;true\n"
+
+	devices, err := readDevicesFromCSV(writeCSV(t, csv))
+	if err != nil {
+		t.Fatalf("readDevicesFromCSV() error = %s", err)
+	}
+	if len(devices) != 2 {
+		t.Fatalf("got %d devices, want 2", len(devices))
+	}
+
+	dev1 := devices[0]
+	if dev1.DevEui != "0102030405060708" {
+		t.Errorf("dev1 DevEui = %q", dev1.DevEui)
+	}
+	if dev1.Activation != ActivationOTAA {
+		t.Errorf("dev1 Activation = %q, want default %q", dev1.Activation, ActivationOTAA)
+	}
+	if dev1.Class != DeviceClassA {
+		t.Errorf("dev1 Class = %q, want default %q", dev1.Class, DeviceClassA)
+	}
+
+	dev2 := devices[1]
+	if dev2.Activation != ActivationABP {
+		t.Errorf("dev2 Activation = %q, want %q", dev2.Activation, ActivationABP)
+	}
+	if dev2.DevAddr != "01020304" {
+		t.Errorf("dev2 DevAddr = %q", dev2.DevAddr)
+	}
+	if dev2.UplinkInterval != 10*time.Second {
+		t.Errorf("dev2 UplinkInterval = %s, want 10s", dev2.UplinkInterval)
+	}
+	if !dev2.ConfirmedUplink {
+		t.Errorf("dev2 ConfirmedUplink = false, want true")
+	}
+}
+
+func TestReadDevicesFromCSVMissingRequiredField(t *testing.T) {
+	csv := "name;profile;dev_eui;nwk_key;join_eui;desc\n" +
+		"dev1;profile1;0102030405060708\n"
+
+	if _, err := readDevicesFromCSV(writeCSV(t, csv)); err == nil {
+		t.Fatal("readDevicesFromCSV() error = nil, want error for row missing required columns")
+	}
+}
+
+func TestReadDevicesFromCSVInvalidUplinkInterval(t *testing.T) {
+	csv := "name;profile;dev_eui;nwk_key;join_eui;desc;activation;class;dev_addr;app_skey;nwk_skey;uplink_interval\n" +
+		"dev1;profile1;0102030405060708;00112233445566778899aabbccddeeff;0000000000000000;desc1;abp;A;01020304;appskey;nwkskey;not-a-duration\n"
+
+	if _, err := readDevicesFromCSV(writeCSV(t, csv)); err == nil {
+		t.Fatal("readDevicesFromCSV() error = nil, want error for invalid uplink_interval")
+	}
+}
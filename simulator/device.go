@@ -0,0 +1,261 @@
+package simulator
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/brocaar/lorawan"
+	"github.com/chirpstack/chirpstack/api/go/v4/gw"
+)
+
+// Device simulates a single LoRaWAN end-device: it periodically sends
+// uplinks through its assigned gateways and, whatever its device class,
+// listens for downlink commands on those gateways.
+type Device struct {
+	devEUI lorawan.EUI64
+
+	appKey    *lorawan.AES128Key
+	otaaDelay time.Duration
+
+	devAddr *lorawan.DevAddr
+	appSKey *lorawan.AES128Key
+	nwkSKey *lorawan.AES128Key
+
+	uplinkInterval time.Duration
+	confirmed      bool
+	fPort          uint8
+	payload        []byte
+
+	gateways []*Gateway
+
+	staticTXInfo gw.UplinkTxInfo
+	txInfoFunc   func() gw.UplinkTxInfo
+
+	class                deviceClass
+	commandTopicTemplate string
+
+	adrHandler        func(dr, txPower int)
+	uplinkSentHandler func(fCnt uint32, sentAt time.Time)
+
+	sessionEstablishedHandler func(devAddr lorawan.DevAddr, appSKey, nwkSKey lorawan.AES128Key)
+
+	fCntMu sync.Mutex
+	fCnt   uint32
+}
+
+// NewDevice creates a new simulated device and starts its uplink loop (and
+// its downlink subscription) in a new goroutine. wg.Done is called once the
+// given context is cancelled and the device has stopped.
+func NewDevice(ctx context.Context, wg *sync.WaitGroup, opts ...DeviceOption) (*Device, error) {
+	var c deviceConfig
+	for _, o := range opts {
+		o(&c)
+	}
+
+	d := &Device{
+		devEUI:                    c.devEUI,
+		appKey:                    c.appKey,
+		otaaDelay:                 c.otaaDelay,
+		devAddr:                   c.devAddr,
+		appSKey:                   c.appSKey,
+		nwkSKey:                   c.nwkSKey,
+		uplinkInterval:            c.uplinkInterval,
+		confirmed:                 c.confirmed,
+		fPort:                     c.fPort,
+		payload:                   c.payload,
+		gateways:                  c.gateways,
+		staticTXInfo:              c.staticTXInfo,
+		txInfoFunc:                c.txInfoFunc,
+		class:                     c.class,
+		commandTopicTemplate:      c.commandTopicTemplate,
+		adrHandler:                c.adrHandler,
+		uplinkSentHandler:         c.uplinkSentHandler,
+		sessionEstablishedHandler: c.sessionEstablishedHandler,
+	}
+
+	wg.Add(1)
+	go d.run(ctx, wg)
+
+	return d, nil
+}
+
+// DevEUI returns the device's EUI.
+func (d *Device) DevEUI() lorawan.EUI64 {
+	return d.devEUI
+}
+
+func (d *Device) run(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	if d.devAddr == nil {
+		// OTAA is emulated: the simulator does not send a real
+		// join-request or parse a join-accept over the air. Instead, once
+		// the configured join delay elapses, it derives a session locally
+		// and hands it to sessionEstablishedHandler, which is expected to
+		// activate that same session server-side (as is done for ABP
+		// devices), so the network-server has a matching DevAddr/session to
+		// validate this device's uplinks against.
+		select {
+		case <-time.After(d.otaaDelay):
+		case <-ctx.Done():
+			return
+		}
+
+		devAddr, appSKey, nwkSKey := deriveSession(d.devEUI, *d.appKey)
+		d.devAddr = &devAddr
+		d.appSKey = &appSKey
+		d.nwkSKey = &nwkSKey
+
+		if d.sessionEstablishedHandler != nil {
+			d.sessionEstablishedHandler(devAddr, appSKey, nwkSKey)
+		}
+	}
+
+	if len(d.gateways) > 0 {
+		// subscribed regardless of device class: class A devices still need
+		// to observe their RX1/RX2 downlink to apply ADR MAC commands, not
+		// just class B/C devices listening continuously for unsolicited
+		// downlinks.
+		d.subscribeClassDownlink()
+	}
+
+	ticker := time.NewTicker(d.uplinkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.sendUplink(); err != nil {
+				log.WithError(err).WithField("dev_eui", d.devEUI.String()).Error("simulator: send uplink error")
+			}
+		}
+	}
+}
+
+// deriveSession deterministically derives a DevAddr and session keys for a
+// device, standing in for the result of a real OTAA join-accept.
+func deriveSession(devEUI lorawan.EUI64, appKey lorawan.AES128Key) (lorawan.DevAddr, lorawan.AES128Key, lorawan.AES128Key) {
+	var devAddr lorawan.DevAddr
+	copy(devAddr[:], devEUI[4:])
+
+	return devAddr, appKey, appKey
+}
+
+func (d *Device) sendUplink() error {
+	d.fCntMu.Lock()
+	fCnt := d.fCnt
+	d.fCnt++
+	d.fCntMu.Unlock()
+
+	mType := lorawan.UnconfirmedDataUp
+	if d.confirmed {
+		mType = lorawan.ConfirmedDataUp
+	}
+
+	fPort := d.fPort
+	phy := lorawan.PHYPayload{
+		MHDR: lorawan.MHDR{
+			MType: mType,
+			Major: lorawan.LoRaWANR1,
+		},
+		MACPayload: &lorawan.MACPayload{
+			FHDR: lorawan.FHDR{
+				DevAddr: *d.devAddr,
+				FCnt:    fCnt,
+			},
+			FPort: &fPort,
+			FRMPayload: []lorawan.Payload{
+				&lorawan.DataPayload{Bytes: d.payload},
+			},
+		},
+	}
+
+	if err := phy.EncryptFRMPayload(*d.appSKey); err != nil {
+		return errors.Wrap(err, "encrypt frm-payload error")
+	}
+	if err := phy.SetUplinkDataMIC(lorawan.LoRaWAN1_0, 0, 0, 0, *d.nwkSKey); err != nil {
+		return errors.Wrap(err, "set uplink data mic error")
+	}
+
+	b, err := phy.MarshalBinary()
+	if err != nil {
+		return errors.Wrap(err, "marshal phy-payload error")
+	}
+
+	txInfo := d.staticTXInfo
+	if d.txInfoFunc != nil {
+		txInfo = d.txInfoFunc()
+	}
+
+	sentAt := time.Now()
+	if d.uplinkSentHandler != nil {
+		d.uplinkSentHandler(fCnt, sentAt)
+	}
+
+	for _, gateway := range d.gateways {
+		if err := gateway.PublishUplink(b, txInfo); err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"dev_eui":    d.devEUI.String(),
+				"gateway_id": gateway.GatewayID().String(),
+			}).Error("simulator: publish uplink error")
+		}
+	}
+
+	return nil
+}
+
+// subscribeClassDownlink subscribes to the command topic of every gateway
+// assigned to this device so that any downlink frame scheduled for it is
+// observed: RX1/RX2 for class A, plus ping-slot (class B) and continuous
+// receive (class C) windows outside of those. This is also how ADR MAC
+// commands reach adrHandler regardless of device class.
+func (d *Device) subscribeClassDownlink() {
+	for _, gateway := range d.gateways {
+		if err := gateway.SubscribeCommand(func(frame *gw.DownlinkFrame) {
+			d.handleDownlinkFrame(frame)
+		}); err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"dev_eui":    d.devEUI.String(),
+				"gateway_id": gateway.GatewayID().String(),
+			}).Error("simulator: subscribe class downlink error")
+		}
+	}
+}
+
+// handleDownlinkFrame inspects a downlink frame scheduled through one of
+// this device's gateways and, if it carries an ADR MAC command, applies it
+// to subsequent uplinks.
+func (d *Device) handleDownlinkFrame(frame *gw.DownlinkFrame) {
+	if d.adrHandler == nil || len(frame.GetItems()) == 0 {
+		return
+	}
+
+	var phy lorawan.PHYPayload
+	if err := phy.UnmarshalBinary(frame.GetItems()[0].GetPhyPayload()); err != nil {
+		return
+	}
+
+	mac, ok := phy.MACPayload.(*lorawan.MACPayload)
+	if !ok {
+		return
+	}
+
+	for _, opt := range mac.FHDR.FOpts {
+		if opt.CID != lorawan.LinkADRReq {
+			continue
+		}
+
+		payload, ok := opt.Payload.(*lorawan.LinkADRReqPayload)
+		if !ok {
+			continue
+		}
+
+		d.adrHandler(int(payload.DataRate), int(payload.TXPower))
+	}
+}
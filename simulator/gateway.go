@@ -0,0 +1,122 @@
+package simulator
+
+import (
+	"bytes"
+	"text/template"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/pkg/errors"
+
+	"github.com/brocaar/lorawan"
+	"github.com/chirpstack/chirpstack/api/go/v4/gw"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// Gateway simulates a single LoRa gateway: it forwards uplinks from the
+// devices assigned to it onto its event topic, and receives downlink
+// commands scheduled for it on its command topic.
+type Gateway struct {
+	gatewayID            lorawan.EUI64
+	mqttClient           mqtt.Client
+	eventTopicTemplate   string
+	commandTopicTemplate string
+}
+
+// NewGateway creates a new simulated gateway.
+func NewGateway(opts ...GatewayOption) (*Gateway, error) {
+	var c gatewayConfig
+	for _, o := range opts {
+		o(&c)
+	}
+
+	return &Gateway{
+		gatewayID:            c.gatewayID,
+		mqttClient:           c.mqttClient,
+		eventTopicTemplate:   c.eventTopicTemplate,
+		commandTopicTemplate: c.commandTopicTemplate,
+	}, nil
+}
+
+// GatewayID returns the gateway's EUI.
+func (g *Gateway) GatewayID() lorawan.EUI64 {
+	return g.gatewayID
+}
+
+// renderTopic renders the gateway's event/command topic template,
+// substituting the gateway ID and the given kind placeholder (event type or
+// command type, e.g. "up" / "down").
+func (g *Gateway) renderTopic(tmpl, kind string) (string, error) {
+	t, err := template.New("topic").Parse(tmpl)
+	if err != nil {
+		return "", errors.Wrap(err, "parse topic template error")
+	}
+
+	var buf bytes.Buffer
+	data := struct {
+		GatewayID   string
+		EventType   string
+		CommandType string
+	}{
+		GatewayID:   g.gatewayID.String(),
+		EventType:   kind,
+		CommandType: kind,
+	}
+	if err := t.Execute(&buf, data); err != nil {
+		return "", errors.Wrap(err, "execute topic template error")
+	}
+
+	return buf.String(), nil
+}
+
+// PublishUplink forwards the given PHYPayload to the network-server as if
+// this gateway had received it over the air.
+func (g *Gateway) PublishUplink(phyPayload []byte, txInfo gw.UplinkTxInfo) error {
+	topic, err := g.renderTopic(g.eventTopicTemplate, "up")
+	if err != nil {
+		return err
+	}
+
+	frame := gw.UplinkFrame{
+		PhyPayload: phyPayload,
+		TxInfo:     &txInfo,
+		RxInfo: &gw.UplinkRxInfo{
+			GatewayId: g.gatewayID.String(),
+		},
+	}
+
+	b, err := protojson.Marshal(&frame)
+	if err != nil {
+		return errors.Wrap(err, "marshal uplink frame error")
+	}
+
+	token := g.mqttClient.Publish(topic, 0, false, b)
+	token.Wait()
+	if token.Error() != nil {
+		return errors.Wrap(token.Error(), "publish uplink frame error")
+	}
+
+	return nil
+}
+
+// SubscribeCommand subscribes to this gateway's command topic and invokes
+// handler for every downlink frame scheduled through it.
+func (g *Gateway) SubscribeCommand(handler func(frame *gw.DownlinkFrame)) error {
+	topic, err := g.renderTopic(g.commandTopicTemplate, "down")
+	if err != nil {
+		return err
+	}
+
+	token := g.mqttClient.Subscribe(topic, 0, func(client mqtt.Client, msg mqtt.Message) {
+		var frame gw.DownlinkFrame
+		if err := protojson.Unmarshal(msg.Payload(), &frame); err != nil {
+			return
+		}
+		handler(&frame)
+	})
+	token.Wait()
+	if token.Error() != nil {
+		return errors.Wrap(token.Error(), "subscribe command topic error")
+	}
+
+	return nil
+}
@@ -0,0 +1,241 @@
+package simulator
+
+import (
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/brocaar/lorawan"
+	"github.com/chirpstack/chirpstack/api/go/v4/gw"
+)
+
+// deviceClass mirrors the LoRaWAN device class a simulated device operates
+// as. It is recorded on the device only: every device with gateways
+// subscribes to their command topics regardless of class, and ping-slot /
+// continuous receive timing is not emulated.
+type deviceClass int
+
+const (
+	classA deviceClass = iota
+	classB
+	classC
+)
+
+// GatewayOption configures a Gateway constructed with NewGateway.
+type GatewayOption func(*gatewayConfig)
+
+type gatewayConfig struct {
+	gatewayID            lorawan.EUI64
+	mqttClient           mqtt.Client
+	eventTopicTemplate   string
+	commandTopicTemplate string
+}
+
+// WithGatewayID sets the gateway's EUI.
+func WithGatewayID(id lorawan.EUI64) GatewayOption {
+	return func(c *gatewayConfig) {
+		c.gatewayID = id
+	}
+}
+
+// WithMQTTClient sets the MQTT client the gateway uses to forward uplinks
+// and receive downlink commands.
+func WithMQTTClient(client mqtt.Client) GatewayOption {
+	return func(c *gatewayConfig) {
+		c.mqttClient = client
+	}
+}
+
+// WithEventTopicTemplate sets the gateway event topic template, e.g.
+// "gateway/{{ .GatewayID }}/event/{{ .EventType }}".
+func WithEventTopicTemplate(tmpl string) GatewayOption {
+	return func(c *gatewayConfig) {
+		c.eventTopicTemplate = tmpl
+	}
+}
+
+// WithCommandTopicTemplate sets the gateway command topic template, e.g.
+// "gateway/{{ .GatewayID }}/command/{{ .CommandType }}".
+func WithCommandTopicTemplate(tmpl string) GatewayOption {
+	return func(c *gatewayConfig) {
+		c.commandTopicTemplate = tmpl
+	}
+}
+
+// DeviceOption configures a Device constructed with NewDevice.
+type DeviceOption func(*deviceConfig)
+
+type deviceConfig struct {
+	devEUI lorawan.EUI64
+
+	// OTAA.
+	appKey    *lorawan.AES128Key
+	otaaDelay time.Duration
+
+	// ABP.
+	devAddr *lorawan.DevAddr
+	appSKey *lorawan.AES128Key
+	nwkSKey *lorawan.AES128Key
+
+	uplinkInterval time.Duration
+	confirmed      bool
+	fPort          uint8
+	payload        []byte
+
+	gateways []*Gateway
+
+	staticTXInfo gw.UplinkTxInfo
+	txInfoFunc   func() gw.UplinkTxInfo
+
+	class                deviceClass
+	commandTopicTemplate string
+
+	adrHandler        func(dr, txPower int)
+	uplinkSentHandler func(fCnt uint32, sentAt time.Time)
+
+	sessionEstablishedHandler func(devAddr lorawan.DevAddr, appSKey, nwkSKey lorawan.AES128Key)
+}
+
+// WithDevEUI sets the device EUI.
+func WithDevEUI(devEUI lorawan.EUI64) DeviceOption {
+	return func(c *deviceConfig) {
+		c.devEUI = devEUI
+	}
+}
+
+// WithAppKey configures the device for OTAA activation with the given
+// AppKey (NwkKey, for LoRaWAN 1.0.x).
+func WithAppKey(appKey lorawan.AES128Key) DeviceOption {
+	return func(c *deviceConfig) {
+		c.appKey = &appKey
+	}
+}
+
+// WithOTAADelay sets the delay before an OTAA device derives its session and
+// starts sending uplinks. No over-the-air join-request is sent.
+func WithOTAADelay(d time.Duration) DeviceOption {
+	return func(c *deviceConfig) {
+		c.otaaDelay = d
+	}
+}
+
+// WithDevAddr configures the device for ABP activation with the given
+// pre-provisioned DevAddr, skipping the join procedure entirely.
+func WithDevAddr(devAddr lorawan.DevAddr) DeviceOption {
+	return func(c *deviceConfig) {
+		c.devAddr = &devAddr
+	}
+}
+
+// WithSessionKeys sets the pre-provisioned AppSKey / NwkSKey used for an
+// ABP-activated device.
+func WithSessionKeys(appSKey, nwkSKey lorawan.AES128Key) DeviceOption {
+	return func(c *deviceConfig) {
+		c.appSKey = &appSKey
+		c.nwkSKey = &nwkSKey
+	}
+}
+
+// WithUplinkInterval sets the interval at which the device sends uplinks.
+func WithUplinkInterval(d time.Duration) DeviceOption {
+	return func(c *deviceConfig) {
+		c.uplinkInterval = d
+	}
+}
+
+// WithUplinkPayload sets the FPort and payload sent on every uplink, and
+// whether the uplink is sent as a confirmed uplink.
+func WithUplinkPayload(confirmed bool, fPort uint8, payload []byte) DeviceOption {
+	return func(c *deviceConfig) {
+		c.confirmed = confirmed
+		c.fPort = fPort
+		c.payload = payload
+	}
+}
+
+// WithConfirmedUplink overrides whether the device's uplinks are sent as
+// confirmed uplinks.
+func WithConfirmedUplink(confirmed bool) DeviceOption {
+	return func(c *deviceConfig) {
+		c.confirmed = confirmed
+	}
+}
+
+// WithGateways sets the gateways that can receive this device's uplinks.
+func WithGateways(gateways []*Gateway) DeviceOption {
+	return func(c *deviceConfig) {
+		c.gateways = gateways
+	}
+}
+
+// WithUplinkTXInfo sets a static UplinkTxInfo used for every transmission.
+func WithUplinkTXInfo(txInfo gw.UplinkTxInfo) DeviceOption {
+	return func(c *deviceConfig) {
+		c.staticTXInfo = txInfo
+	}
+}
+
+// WithUplinkTXInfoFunc sets a function that is called to obtain the
+// UplinkTxInfo for each individual transmission, e.g. to rotate through a
+// region's channel plan instead of using a single static channel.
+func WithUplinkTXInfoFunc(fn func() gw.UplinkTxInfo) DeviceOption {
+	return func(c *deviceConfig) {
+		c.txInfoFunc = fn
+	}
+}
+
+// WithADRHandler registers a callback that is invoked with the DR and
+// TxPower carried by an ADR MAC command received from the network-server,
+// so that the caller can apply it to subsequent uplinks (e.g. through the
+// same func passed to WithUplinkTXInfoFunc).
+func WithADRHandler(fn func(dr, txPower int)) DeviceOption {
+	return func(c *deviceConfig) {
+		c.adrHandler = fn
+	}
+}
+
+// WithUplinkSentHandler registers a callback invoked with the frame-counter
+// and local send time of every uplink transmission, so that the round-trip
+// latency to the application integration event can be measured against a
+// timestamp the simulator itself stamped, rather than trusting a gateway or
+// network-server supplied time.
+func WithUplinkSentHandler(fn func(fCnt uint32, sentAt time.Time)) DeviceOption {
+	return func(c *deviceConfig) {
+		c.uplinkSentHandler = fn
+	}
+}
+
+// WithSessionEstablishedHandler registers a callback invoked once an OTAA
+// device has derived its session, with the DevAddr and session keys it
+// derived. The simulator does not perform a real over-the-air join-request/
+// join-accept exchange; the caller is expected to use this to activate the
+// same session server-side (as it would for an ABP device), so that the
+// network-server actually has a matching DevAddr/session to validate the
+// device's uplinks against.
+func WithSessionEstablishedHandler(fn func(devAddr lorawan.DevAddr, appSKey, nwkSKey lorawan.AES128Key)) DeviceOption {
+	return func(c *deviceConfig) {
+		c.sessionEstablishedHandler = fn
+	}
+}
+
+// WithClassB marks the device as class B and records commandTopicTemplate.
+// Neither changes which downlinks the device observes: it subscribes to its
+// gateways' command topics regardless of class, using each gateway's own
+// command topic template.
+func WithClassB(commandTopicTemplate string) DeviceOption {
+	return func(c *deviceConfig) {
+		c.class = classB
+		c.commandTopicTemplate = commandTopicTemplate
+	}
+}
+
+// WithClassC marks the device as class C and records commandTopicTemplate.
+// Neither changes which downlinks the device observes: it subscribes to its
+// gateways' command topics regardless of class, using each gateway's own
+// command topic template.
+func WithClassC(commandTopicTemplate string) DeviceOption {
+	return func(c *deviceConfig) {
+		c.class = classC
+		c.commandTopicTemplate = commandTopicTemplate
+	}
+}